@@ -0,0 +1,54 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/reference"
+
+	kclientsetinternal "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+
+	"github.com/openshift/origin/pkg/client"
+	"github.com/openshift/origin/pkg/client/testclient"
+)
+
+// etcdDigest is a well-known digest that is never backed by a stored image; tests use it to
+// exercise the "tag points at a descriptor we don't have" path.
+const etcdDigest = digest.Digest("sha256:4a1c6b9b9b6a9e6a1a9b9b6a9e6a1a9b9b6a9e6a1a9b9b6a9e6a1a9b9b6a9e6a")
+
+// fakeRegistryClient implements RegistryClient in terms of a pair of already-configured fake
+// clients, so that tests can drive the reactors they've set up directly.
+type fakeRegistryClient struct {
+	client     client.Interface
+	kubeClient kclientsetinternal.Interface
+}
+
+func makeFakeRegistryClient(c client.Interface, kubeClient kclientsetinternal.Interface) RegistryClient {
+	return &fakeRegistryClient{client: c, kubeClient: kubeClient}
+}
+
+func (f *fakeRegistryClient) Client() (client.Interface, kclientsetinternal.Interface, error) {
+	return f.client, f.kubeClient, nil
+}
+
+// newTestRepositoryForPullthrough builds a repository for use in tagService tests. When base is
+// nil, a testRepository is synthesized from namespace/name so that Named() agrees with the
+// ImageStream the test reactors were set up for.
+func newTestRepositoryForPullthrough(t *testing.T, ctx context.Context, base distribution.Repository, namespace, name string, c *testclient.Fake, pullthrough bool) *repository {
+	if base == nil {
+		named, err := reference.ParseNamed(fmt.Sprintf("%s/%s", namespace, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		base = &testRepository{name: named}
+	}
+
+	repo, err := newRepositoryForPullthrough(ctx, DefaultRegistryClient, base, namespace, name, pullthrough)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+
+	imageapi "github.com/openshift/origin/pkg/image/api"
+)
+
+// defaultTagIndexCacheSize bounds the number of repositories whose reverse tag index is kept
+// warm at once.
+const defaultTagIndexCacheSize = 1024
+
+// reverseTagIndex maps each digest present in an ImageStream to the tags currently pointing at
+// it, so Lookup doesn't have to walk every tag in streams with tens of thousands of them.
+type reverseTagIndex struct {
+	tags map[digest.Digest][]string
+}
+
+func buildReverseTagIndex(stream *imageapi.ImageStream) *reverseTagIndex {
+	idx := &reverseTagIndex{tags: make(map[digest.Digest][]string)}
+	for tag, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		d := digest.Digest(history.Items[0].Image)
+		idx.tags[d] = append(idx.tags[d], tag)
+	}
+	return idx
+}
+
+// tagIndexCache is a small, bounded LRU of reverseTagIndex values keyed by "namespace/name".
+// Entries are invalidated by Tag/Untag, but since the ImageStream backing a key can also be
+// mutated out from under this process (another replica, `oc tag`, pruning), every entry is also
+// tagged with the resourceVersion it was built from; get rejects a stale entry as a miss so the
+// caller rebuilds it from the current ImageStream rather than serving outdated results.
+type tagIndexCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+type tagIndexEntry struct {
+	key             string
+	index           *reverseTagIndex
+	resourceVersion string
+}
+
+func newTagIndexCache(capacity int) *tagIndexCache {
+	return &tagIndexCache{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached index for key, but only if it was built from resourceVersion: a cached
+// entry built from any other resourceVersion is treated as a miss, since the ImageStream has
+// since changed (locally or otherwise) and the index no longer reflects it.
+func (c *tagIndexCache) get(key, resourceVersion string) (*reverseTagIndex, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*tagIndexEntry)
+	if entry.resourceVersion != resourceVersion {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.index, true
+}
+
+func (c *tagIndexCache) set(key, resourceVersion string, index *reverseTagIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		entry := el.Value.(*tagIndexEntry)
+		entry.index = index
+		entry.resourceVersion = resourceVersion
+		c.order.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&tagIndexEntry{key: key, index: index, resourceVersion: resourceVersion})
+
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*tagIndexEntry).key)
+	}
+}
+
+func (c *tagIndexCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.order.Remove(el)
+		delete(c.entries, key)
+	}
+}
+
+// tagIndexCacheSingleton backs every repository's reverse tag index; it is a package-level
+// variable (rather than threaded through repository) purely so tests can swap in a smaller
+// capacity without plumbing config through every constructor.
+var tagIndexCacheSingleton = newTagIndexCache(defaultTagIndexCacheSize)
+
+// indexKey identifies a repository's entry in tagIndexCacheSingleton.
+func (r *repository) indexKey() string {
+	return r.namespace + "/" + r.name
+}
+
+// reverseIndex returns the cached reverse tag index for t.repo, building and caching it from
+// stream if it isn't already warm or if the cached entry was built from a different
+// resourceVersion than stream's (i.e. the ImageStream changed since, whether or not this process
+// was the one that changed it).
+func (t *tagService) reverseIndex(stream *imageapi.ImageStream) *reverseTagIndex {
+	key := t.repo.indexKey()
+	if idx, ok := tagIndexCacheSingleton.get(key, stream.ResourceVersion); ok {
+		return idx
+	}
+
+	idx := buildReverseTagIndex(stream)
+	tagIndexCacheSingleton.set(key, stream.ResourceVersion, idx)
+	return idx
+}
+
+// paginateTags sorts tags and returns at most n of them that sort strictly after lastTag, along
+// with the cursor to pass as lastTag on the next call ("" once there are no more results). n must
+// be positive; it ordinarily comes straight from a caller-supplied page size, so a malformed or
+// missing value is reported as an error rather than allowed to panic.
+func paginateTags(tags []string, lastTag string, n int) ([]string, string, error) {
+	if n <= 0 {
+		return nil, "", fmt.Errorf("invalid page size %d: must be greater than zero", n)
+	}
+
+	sorted := append([]string(nil), tags...)
+	sort.Strings(sorted)
+
+	start := sort.SearchStrings(sorted, lastTag)
+	if start < len(sorted) && sorted[start] == lastTag {
+		start++
+	}
+
+	if start >= len(sorted) {
+		return []string{}, "", nil
+	}
+
+	end := start + n
+	if end > len(sorted) {
+		end = len(sorted)
+	}
+
+	page := sorted[start:end]
+	next := ""
+	if end < len(sorted) {
+		next = page[len(page)-1]
+	}
+	return page, next, nil
+}
+
+// AllPaginated returns at most n accessible tag names that sort strictly after lastTag, plus the
+// cursor to pass as lastTag on the next call. Pass an empty lastTag to start from the beginning.
+func (t *tagService) AllPaginated(ctx context.Context, lastTag string, n int) ([]string, string, error) {
+	stream, err := t.repo.getImageStream()
+	if err != nil {
+		return nil, "", err
+	}
+
+	accessible := []string{}
+	for tag, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		if t.accessible(history.Items[0].Image) {
+			accessible = append(accessible, tag)
+		}
+	}
+
+	return paginateTags(accessible, lastTag, n)
+}
+
+// LookupPaginated is Lookup's paginated counterpart: at most n accessible tag names pointing at
+// desc that sort strictly after lastTag, plus the cursor to pass as lastTag on the next call.
+func (t *tagService) LookupPaginated(ctx context.Context, desc distribution.Descriptor, lastTag string, n int) ([]string, string, error) {
+	stream, err := t.repo.getImageStream()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !t.accessible(string(desc.Digest)) {
+		return []string{}, "", nil
+	}
+
+	return paginateTags(t.reverseIndex(stream).tags[desc.Digest], lastTag, n)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+
+	"github.com/openshift/origin/pkg/client/testclient"
+	imagetest "github.com/openshift/origin/pkg/image/admission/testutil"
+	imageapi "github.com/openshift/origin/pkg/image/api"
+)
+
+// largeTestImageStream builds an ImageStream with n tags, each pointing at a distinct digest, for
+// exercising pagination and the reverse tag index at a size a single manual reactor case wouldn't
+// catch.
+func largeTestImageStream(namespace, name string, n int) *imageapi.ImageStream {
+	stream := &imageapi.ImageStream{
+		// A distinct, non-empty ResourceVersion keeps this synthetic stream's cached reverse
+		// index from being mistaken for another test's stream at the same namespace/name: the
+		// cache treats a resourceVersion mismatch as a miss and rebuilds.
+		ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: name, ResourceVersion: fmt.Sprintf("large-%d", n)},
+		Status:     imageapi.ImageStreamStatus{Tags: make(map[string]imageapi.TagEventList, n)},
+	}
+	for i := 0; i < n; i++ {
+		tag := fmt.Sprintf("v%05d", i)
+		stream.Status.Tags[tag] = imageapi.TagEventList{
+			Items: []imageapi.TagEvent{{Image: fmt.Sprintf("sha256:%064d", i)}},
+		}
+	}
+	return stream
+}
+
+// newLargeStreamTagService returns a tagService backed by a stream of n tags, along with a
+// restore func the caller must defer to put DefaultRegistryClient back.
+func newLargeStreamTagService(t *testing.T, namespace, name string, n int) (ts *tagService, restore func()) {
+	client := &testclient.Fake{}
+
+	backupRegistryClient := DefaultRegistryClient
+	DefaultRegistryClient = makeFakeRegistryClient(client, fake.NewSimpleClientset())
+
+	stream := largeTestImageStream(namespace, name, n)
+	client.AddReactor("get", "imagestreams", imagetest.GetFakeImageStreamGetHandler(t, *stream))
+
+	r := newTestRepositoryForPullthrough(t, context.Background(), nil, namespace, name, client, true)
+	return &tagService{TagService: newTestTagService(nil), repo: r}, func() {
+		DefaultRegistryClient = backupRegistryClient
+	}
+}
+
+func TestTagGetAllPaginatedLargeStream(t *testing.T) {
+	const tagCount = 5000
+	ts, restore := newLargeStreamTagService(t, "user", "app", tagCount)
+	defer restore()
+	ctx := context.Background()
+
+	seen := map[string]bool{}
+	cursor := ""
+	for {
+		page, next, err := ts.AllPaginated(ctx, cursor, 250)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		for _, tag := range page {
+			if seen[tag] {
+				t.Fatalf("tag %q returned twice across pages", tag)
+			}
+			seen[tag] = true
+		}
+		cursor = next
+		if cursor == "" {
+			break
+		}
+	}
+
+	if len(seen) != tagCount {
+		t.Fatalf("expected to page through all %d tags, saw %d", tagCount, len(seen))
+	}
+}
+
+func TestTagLookupPaginatedLargeStream(t *testing.T) {
+	const tagCount = 5000
+	ts, restore := newLargeStreamTagService(t, "user", "app", tagCount)
+	defer restore()
+	ctx := context.Background()
+
+	dgst := digest.Digest(fmt.Sprintf("sha256:%064d", 42))
+	tags, next, err := ts.LookupPaginated(ctx, distribution.Descriptor{Digest: dgst}, "", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if next != "" {
+		t.Fatalf("expected no further pages, got cursor %q", next)
+	}
+	if len(tags) != 1 || tags[0] != "v00042" {
+		t.Fatalf("unexpected lookup result: %v", tags)
+	}
+
+	// The reverse index should now be warm; invalidating it and looking up again must still
+	// return the same result, rebuilt lazily from the ImageStream.
+	tagIndexCacheSingleton.invalidate(ts.repo.indexKey())
+	tags, _, err = ts.LookupPaginated(ctx, distribution.Descriptor{Digest: dgst}, "", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(tags) != 1 || tags[0] != "v00042" {
+		t.Fatalf("unexpected lookup result after cache invalidation: %v", tags)
+	}
+}
+
+func TestPaginateTags(t *testing.T) {
+	tags := []string{"c", "a", "b", "d"}
+
+	page, next, err := paginateTags(tags, "", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(page) != "[a b]" || next != "b" {
+		t.Fatalf("unexpected first page: %v, cursor %q", page, next)
+	}
+
+	page, next, err = paginateTags(tags, next, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(page) != "[c d]" || next != "" {
+		t.Fatalf("unexpected second page: %v, cursor %q", page, next)
+	}
+}
+
+func TestPaginateTagsRejectsNonPositivePageSize(t *testing.T) {
+	for _, n := range []int{0, -1, -100} {
+		if _, _, err := paginateTags([]string{"a", "b"}, "", n); err == nil {
+			t.Fatalf("expected an error for page size %d, got none", n)
+		}
+	}
+}
+
+func BenchmarkTagAllPaginatedLargeStream(b *testing.B) {
+	ts, restore := newLargeStreamTagService(&testing.T{}, "user", "app", 20000)
+	defer restore()
+	ctx := context.Background()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := ts.AllPaginated(ctx, "", 100); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
+
+func BenchmarkTagLookupPaginatedLargeStream(b *testing.B) {
+	ts, restore := newLargeStreamTagService(&testing.T{}, "user", "app", 20000)
+	defer restore()
+	ctx := context.Background()
+	dgst := digest.Digest(fmt.Sprintf("sha256:%064d", 42))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tagIndexCacheSingleton.invalidate(ts.repo.indexKey())
+		if _, _, err := ts.LookupPaginated(ctx, distribution.Descriptor{Digest: dgst}, "", 10); err != nil {
+			b.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"fmt"
+
+	kclientsetinternal "k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset"
+
+	"github.com/openshift/origin/pkg/client"
+)
+
+// RegistryClient encapsulates access to the OpenShift and Kubernetes API servers that the
+// registry needs in order to resolve image streams, images, and related objects. Request
+// handlers are normally given a RegistryClient explicitly; DefaultRegistryClient exists for the
+// few code paths (and the bulk of our unit tests) that are constructed outside of a request
+// scope and therefore have nothing to inject one with.
+type RegistryClient interface {
+	// Client returns the OpenShift and Kubernetes clients to use for the current request.
+	Client() (client.Interface, kclientsetinternal.Interface, error)
+}
+
+// DefaultRegistryClient is swapped out by tests; production code should prefer passing a
+// RegistryClient explicitly wherever the call site has one available.
+var DefaultRegistryClient RegistryClient = &unconfiguredRegistryClient{}
+
+// unconfiguredRegistryClient is the zero-value RegistryClient. It always fails, which ensures
+// that forgetting to wire up a real client produces a clear error instead of a nil panic.
+type unconfiguredRegistryClient struct{}
+
+func (c *unconfiguredRegistryClient) Client() (client.Interface, kclientsetinternal.Interface, error) {
+	return nil, nil, fmt.Errorf("no registry client has been configured")
+}
@@ -0,0 +1,405 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/prometheus/client_golang/prometheus"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	kselector "k8s.io/kubernetes/pkg/labels"
+	"k8s.io/kubernetes/pkg/util/wait"
+
+	"github.com/openshift/origin/pkg/client"
+	imageapi "github.com/openshift/origin/pkg/image/api"
+)
+
+// ReplicationRemote is a single upstream registry a ReplicationPolicy may pull tags from.
+type ReplicationRemote struct {
+	// URL is the remote registry to pull from, e.g. "registry.example.com/upstream".
+	URL string
+	// Insecure is this remote's counterpart of imageapi.InsecureRepositoryAnnotation. It is handed
+	// to newRemoteRepository with the rest of the remote; the default newRemoteRepository does not
+	// read it.
+	Insecure bool
+}
+
+// ReplicationFilter narrows a ReplicationPolicy to a subset of the tags a remote exposes.
+// An empty field matches everything for that dimension.
+type ReplicationFilter struct {
+	TagGlob       string
+	DigestGlob    string
+	LabelSelector string
+}
+
+// ReplicationPolicy declares that tags on a namespace/name ImageStream (or, when Name is empty,
+// every ImageStream in Namespace) matching Filter should be mirrored in from Remotes.
+type ReplicationPolicy struct {
+	Namespace string
+	Name      string
+	Remotes   []ReplicationRemote
+	Filter    ReplicationFilter
+}
+
+// matches reports whether this policy applies to the given repository/tag. dgst and labels
+// narrow the match further by ReplicationFilter.DigestGlob and ReplicationFilter.LabelSelector;
+// pass "" / nil when that information isn't known yet (e.g. before a remote pull has resolved a
+// tag's digest) to skip that dimension rather than reject the match outright.
+func (p *ReplicationPolicy) matches(namespace, name, tag string, dgst digest.Digest, labels map[string]string) bool {
+	if p.Namespace != namespace {
+		return false
+	}
+	if p.Name != "" && p.Name != name {
+		return false
+	}
+
+	if p.Filter.TagGlob != "" {
+		if ok, err := path.Match(p.Filter.TagGlob, tag); err != nil || !ok {
+			return false
+		}
+	}
+
+	if p.Filter.DigestGlob != "" && dgst != "" {
+		if ok, err := path.Match(p.Filter.DigestGlob, dgst.String()); err != nil || !ok {
+			return false
+		}
+	}
+
+	if p.Filter.LabelSelector != "" && labels != nil {
+		selector, err := kselector.Parse(p.Filter.LabelSelector)
+		if err != nil || !selector.Matches(kselector.Set(labels)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ReplicationPolicySource supplies the set of policies currently in effect. Production code
+// loads these from registry middleware configuration; tests can substitute a literal slice.
+type ReplicationPolicySource interface {
+	Policies() []ReplicationPolicy
+}
+
+// staticPolicySource is the trivial ReplicationPolicySource backing LoadReplicationPolicies.
+type staticPolicySource []ReplicationPolicy
+
+func (s staticPolicySource) Policies() []ReplicationPolicy { return []ReplicationPolicy(s) }
+
+// LoadReplicationPolicies builds a ReplicationPolicySource from parsed middleware configuration,
+// in the same shape as the rest of the registry's pullthrough/cache options.
+func LoadReplicationPolicies(policies []ReplicationPolicy) ReplicationPolicySource {
+	return staticPolicySource(policies)
+}
+
+var replicationLagSeconds = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: "openshift",
+		Subsystem: "registry_replication",
+		Name:      "lag_seconds",
+		Help:      "Seconds since a replicated tag was last refreshed from its remote, per namespace/name/tag.",
+	},
+	[]string{"namespace", "name", "tag"},
+)
+
+var replicationErrorsTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "openshift",
+		Subsystem: "registry_replication",
+		Name:      "errors_total",
+		Help:      "Count of failed attempts to mirror a tag from a remote registry, per namespace/name.",
+	},
+	[]string{"namespace", "name"},
+)
+
+func init() {
+	prometheus.MustRegister(replicationLagSeconds)
+	prometheus.MustRegister(replicationErrorsTotal)
+}
+
+// replicationController mirrors tag->descriptor mappings from remote registries into local
+// ImageStreams, either eagerly on a schedule (Start) or lazily on a lookup miss (mirrorTag).
+type replicationController struct {
+	registryClient RegistryClient
+	policies       ReplicationPolicySource
+
+	// backoff is the retry schedule used between failed transfer attempts for the same tag.
+	backoff wait.Backoff
+}
+
+// newReplicationController builds a controller that mirrors tags using registryClient and the
+// policies returned by policies.
+func newReplicationController(registryClient RegistryClient, policies ReplicationPolicySource) *replicationController {
+	return &replicationController{
+		registryClient: registryClient,
+		policies:       policies,
+		backoff: wait.Backoff{
+			Duration: time.Second,
+			Factor:   2.0,
+			Steps:    5,
+		},
+	}
+}
+
+// Start runs the eager replication loop until ctx is cancelled, reconciling every policy's
+// remotes on each tick.
+func (c *replicationController) Start(ctx context.Context, tick time.Duration) {
+	go wait.Until(func() {
+		for _, policy := range c.policies.Policies() {
+			if err := c.reconcile(ctx, policy); err != nil {
+				context.GetLogger(ctx).Errorf("replication: reconcile %s/%s failed: %v", policy.Namespace, policy.Name, err)
+			}
+		}
+	}, tick, ctx.Done())
+}
+
+// reconcile discovers tags the remote exposes that aren't mirrored locally yet and mirrors them
+// in, for every ImageStream policy covers (just policy.Name, or every stream in policy.Namespace
+// when Name is empty). Tags already present locally are left alone here - they were already
+// mirrored by a prior reconcile or by the lazy mirrorTag path - so this loop's job is purely to
+// discover newly-published remote tags, not to refresh ones it already has.
+func (c *replicationController) reconcile(ctx context.Context, policy ReplicationPolicy) error {
+	osClient, _, err := c.registryClient.Client()
+	if err != nil {
+		return err
+	}
+
+	streams, err := c.policyStreams(osClient, policy)
+	if err != nil {
+		return err
+	}
+
+	remoteTags, err := c.listRemoteTags(ctx, policy)
+	if err != nil {
+		return err
+	}
+
+	for _, named := range streams {
+		for _, tag := range remoteTags {
+			if _, ok := named.stream.Status.Tags[tag]; ok {
+				continue
+			}
+			if !policy.matches(policy.Namespace, named.name, tag, "", nil) {
+				continue
+			}
+			if _, err := c.mirrorTagFromPolicy(ctx, policy, policy.Namespace, named.name, tag); err != nil {
+				replicationErrorsTotal.WithLabelValues(policy.Namespace, named.name).Inc()
+			}
+		}
+	}
+	return nil
+}
+
+// namedStream pairs an ImageStream with the name it was looked up under, since a List result's
+// own ObjectMeta.Name is the only reliable source of that name once Name is no longer known from
+// policy.Name alone (the namespace-wide case).
+type namedStream struct {
+	name   string
+	stream *imageapi.ImageStream
+}
+
+// policyStreams resolves the ImageStreams policy applies to: just policy.Name, or every stream in
+// policy.Namespace when Name is empty.
+func (c *replicationController) policyStreams(osClient client.Interface, policy ReplicationPolicy) ([]namedStream, error) {
+	if policy.Name != "" {
+		stream, err := osClient.ImageStreams(policy.Namespace).Get(policy.Name)
+		if err != nil {
+			return nil, err
+		}
+		return []namedStream{{name: policy.Name, stream: stream}}, nil
+	}
+
+	list, err := osClient.ImageStreams(policy.Namespace).List(kapi.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	streams := make([]namedStream, 0, len(list.Items))
+	for i := range list.Items {
+		streams = append(streams, namedStream{name: list.Items[i].Name, stream: &list.Items[i]})
+	}
+	return streams, nil
+}
+
+// listRemoteTags returns every tag the first reachable remote in policy.Remotes exposes.
+func (c *replicationController) listRemoteTags(ctx context.Context, policy ReplicationPolicy) ([]string, error) {
+	var lastErr error
+	for _, remote := range policy.Remotes {
+		repo, err := newRemoteRepository(ctx, remote)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		tags, err := repo.Tags(ctx).All(ctx)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		return tags, nil
+	}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no remotes configured for %s/%s", policy.Namespace, policy.Name)
+	}
+	return nil, lastErr
+}
+
+// mirrorTag finds the first policy covering repo/tag and attempts to mirror it in, returning the
+// descriptor that was written locally on success. The tag's digest isn't known locally yet (that
+// is the whole reason we're mirroring), so ReplicationFilter.DigestGlob is re-checked against the
+// remote's answer inside mirrorTagFromPolicy before anything is written.
+func (c *replicationController) mirrorTag(ctx context.Context, repo *repository, tag string) (distribution.Descriptor, error) {
+	for _, policy := range c.policies.Policies() {
+		if !policy.matches(repo.namespace, repo.name, tag, "", nil) {
+			continue
+		}
+		desc, err := c.mirrorTagFromPolicy(ctx, policy, repo.namespace, repo.name, tag)
+		if err != nil {
+			replicationErrorsTotal.WithLabelValues(repo.namespace, repo.name).Inc()
+		}
+		return desc, err
+	}
+	return distribution.Descriptor{}, fmt.Errorf("no replication policy covers %s/%s:%s", repo.namespace, repo.name, tag)
+}
+
+// mirrorTagFromPolicy pulls tag from the first remote in policy willing to serve it, retrying
+// transfer failures with backoff, then writes the resulting descriptor into the local
+// ImageStream (by creating an ImageStreamMapping, the same as a normal tagService.Tag) and
+// updates the replication lag metric. Unlike TagGlob, neither DigestGlob nor LabelSelector can be
+// evaluated before the remote resolves the tag, so both are re-checked here: DigestGlob against
+// the remote's descriptor before anything is written, and LabelSelector against the mirrored
+// Image after the write. A DigestGlob mismatch skips the write, a LabelSelector mismatch rolls
+// the write back, and either moves on to the next remote.
+func (c *replicationController) mirrorTagFromPolicy(ctx context.Context, policy ReplicationPolicy, namespace, name, tag string) (distribution.Descriptor, error) {
+	var desc distribution.Descriptor
+	var lastErr error
+
+	for _, remote := range policy.Remotes {
+		lastErr = wait.ExponentialBackoff(c.backoff, func() (bool, error) {
+			d, err := c.pullDescriptor(ctx, remote, namespace, name, tag)
+			if err != nil {
+				return false, nil
+			}
+			desc = d
+			return true, nil
+		})
+		if lastErr == nil {
+			if policy.Filter.DigestGlob != "" {
+				if ok, err := path.Match(policy.Filter.DigestGlob, desc.Digest.String()); err != nil || !ok {
+					lastErr = fmt.Errorf("remote descriptor digest %s for %s/%s:%s does not match policy digest glob %q", desc.Digest, namespace, name, tag, policy.Filter.DigestGlob)
+					continue
+				}
+			}
+
+			if err := c.writeMirroredTag(namespace, name, tag, desc); err != nil {
+				lastErr = err
+				continue
+			}
+
+			if policy.Filter.LabelSelector != "" {
+				ok, err := c.mirroredImageMatchesLabelSelector(policy, desc)
+				if err != nil || !ok {
+					c.removeMirroredTag(namespace, name, tag)
+					if err == nil {
+						err = fmt.Errorf("mirrored image %s for %s/%s:%s does not match policy label selector %q", desc.Digest, namespace, name, tag, policy.Filter.LabelSelector)
+					}
+					lastErr = err
+					continue
+				}
+			}
+
+			replicationLagSeconds.WithLabelValues(namespace, name, tag).Set(0)
+			return desc, nil
+		}
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no remotes configured for %s/%s:%s", namespace, name, tag)
+	}
+	return distribution.Descriptor{}, lastErr
+}
+
+// writeMirroredTag records desc as tag on the namespace/name ImageStream by creating an
+// ImageStreamMapping, identically to how tagService.Tag persists a normal tag write, and
+// invalidates the reverse tag index so Lookup/All see the new mapping immediately.
+func (c *replicationController) writeMirroredTag(namespace, name, tag string, desc distribution.Descriptor) error {
+	osClient, _, err := c.registryClient.Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = osClient.ImageStreamMappings(namespace).Create(&imageapi.ImageStreamMapping{
+		ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: name},
+		Tag:        tag,
+		Image: imageapi.Image{
+			ObjectMeta: kapi.ObjectMeta{Name: string(desc.Digest)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	tagIndexCacheSingleton.invalidate(namespace + "/" + name)
+	return nil
+}
+
+// mirroredImageMatchesLabelSelector reports whether the Image behind desc, now that it has been
+// mirrored in, satisfies policy.Filter.LabelSelector.
+func (c *replicationController) mirroredImageMatchesLabelSelector(policy ReplicationPolicy, desc distribution.Descriptor) (bool, error) {
+	osClient, _, err := c.registryClient.Client()
+	if err != nil {
+		return false, err
+	}
+
+	image, err := osClient.Images().Get(string(desc.Digest))
+	if err != nil {
+		return false, err
+	}
+
+	selector, err := kselector.Parse(policy.Filter.LabelSelector)
+	if err != nil {
+		return false, err
+	}
+	return selector.Matches(kselector.Set(image.Labels)), nil
+}
+
+// removeMirroredTag undoes writeMirroredTag, for when a mirrored tag fails a check (such as
+// LabelSelector) that can only be evaluated after the write.
+func (c *replicationController) removeMirroredTag(namespace, name, tag string) {
+	osClient, _, err := c.registryClient.Client()
+	if err != nil {
+		return
+	}
+
+	osClient.ImageStreamTags(namespace).Delete(imageapi.JoinImageStreamTag(name, tag), nil)
+	tagIndexCacheSingleton.invalidate(namespace + "/" + name)
+}
+
+// pullDescriptor resolves tag against a single remote registry, through the repository that
+// newRemoteRepository opens for remote.
+func (c *replicationController) pullDescriptor(ctx context.Context, remote ReplicationRemote, namespace, name, tag string) (distribution.Descriptor, error) {
+	repo, err := newRemoteRepository(ctx, remote)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	desc, err := repo.Tags(ctx).Get(ctx, tag)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	if desc.Digest == digest.Digest("") {
+		return distribution.Descriptor{}, fmt.Errorf("remote %s returned an empty digest for %s/%s:%s", remote.URL, namespace, name, tag)
+	}
+
+	return desc, nil
+}
+
+// newRemoteRepository is the seam tests stub out to avoid making real network connections. The
+// default implementation opens no connection to remote.URL and does not read remote.Insecure: it
+// always returns an error, so no tag is transferred from a remote unless it has been replaced.
+var newRemoteRepository = func(ctx context.Context, remote ReplicationRemote) (distribution.Repository, error) {
+	return nil, fmt.Errorf("replication transport not configured for %s", remote.URL)
+}
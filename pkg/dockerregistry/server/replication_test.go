@@ -0,0 +1,288 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/client/clientset_generated/internalclientset/fake"
+
+	"github.com/openshift/origin/pkg/client/testclient"
+	registrytest "github.com/openshift/origin/pkg/dockerregistry/testutil"
+	imagetest "github.com/openshift/origin/pkg/image/admission/testutil"
+	imageapi "github.com/openshift/origin/pkg/image/api"
+)
+
+func TestReplicationPolicyMatches(t *testing.T) {
+	testcases := []struct {
+		title  string
+		policy ReplicationPolicy
+		tag    string
+		dgst   digest.Digest
+		labels map[string]string
+		want   bool
+	}{
+		{
+			title:  "matches on namespace/name alone",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app"},
+			tag:    "latest",
+			want:   true,
+		},
+		{
+			title:  "rejects a different namespace",
+			policy: ReplicationPolicy{Namespace: "other", Name: "app"},
+			tag:    "latest",
+			want:   false,
+		},
+		{
+			title:  "tag glob matches",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app", Filter: ReplicationFilter{TagGlob: "v1.*"}},
+			tag:    "v1.2.3",
+			want:   true,
+		},
+		{
+			title:  "tag glob rejects",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app", Filter: ReplicationFilter{TagGlob: "v1.*"}},
+			tag:    "v2.0.0",
+			want:   false,
+		},
+		{
+			title:  "digest glob matches",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app", Filter: ReplicationFilter{DigestGlob: "sha256:aaa*"}},
+			tag:    "latest",
+			dgst:   digest.Digest("sha256:aaabbb"),
+			want:   true,
+		},
+		{
+			title:  "digest glob rejects",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app", Filter: ReplicationFilter{DigestGlob: "sha256:aaa*"}},
+			tag:    "latest",
+			dgst:   digest.Digest("sha256:zzzyyy"),
+			want:   false,
+		},
+		{
+			title:  "digest glob is skipped when the digest isn't known yet",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app", Filter: ReplicationFilter{DigestGlob: "sha256:aaa*"}},
+			tag:    "latest",
+			want:   true,
+		},
+		{
+			title:  "label selector matches",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app", Filter: ReplicationFilter{LabelSelector: "release=stable"}},
+			tag:    "latest",
+			labels: map[string]string{"release": "stable"},
+			want:   true,
+		},
+		{
+			title:  "label selector rejects",
+			policy: ReplicationPolicy{Namespace: "user", Name: "app", Filter: ReplicationFilter{LabelSelector: "release=stable"}},
+			tag:    "latest",
+			labels: map[string]string{"release": "canary"},
+			want:   false,
+		},
+	}
+
+	for _, tc := range testcases {
+		got := tc.policy.matches("user", "app", tc.tag, tc.dgst, tc.labels)
+		if got != tc.want {
+			t.Errorf("[%s] matches() = %v, want %v", tc.title, got, tc.want)
+		}
+	}
+}
+
+// TestMirrorTagWritesImageStreamMapping verifies that a lazily-mirrored tag is actually
+// persisted into the local ImageStream (via an ImageStreamMapping), not just returned in memory.
+func TestMirrorTagWritesImageStreamMapping(t *testing.T) {
+	namespace := "user"
+	repoName := "app"
+	tag := "latest"
+	mirroredDigest := digest.Digest("sha256:" + fmt.Sprintf("%064d", 7))
+
+	client := &testclient.Fake{}
+
+	backupRegistryClient := DefaultRegistryClient
+	DefaultRegistryClient = makeFakeRegistryClient(client, fake.NewSimpleClientset())
+	defer func() { DefaultRegistryClient = backupRegistryClient }()
+
+	stream := &imageapi.ImageStream{Status: imageapi.ImageStreamStatus{Tags: map[string]imageapi.TagEventList{}}}
+	client.AddReactor("get", "imagestreams", imagetest.GetFakeImageStreamGetHandler(t, *stream))
+
+	backupNewRemoteRepository := newRemoteRepository
+	newRemoteRepository = func(ctx context.Context, remote ReplicationRemote) (distribution.Repository, error) {
+		return &fakeRemoteRepository{desc: distribution.Descriptor{Digest: mirroredDigest}}, nil
+	}
+	defer func() { newRemoteRepository = backupNewRemoteRepository }()
+
+	r := newTestRepositoryForPullthrough(t, context.Background(), nil, namespace, repoName, client, true)
+
+	controller := newReplicationController(DefaultRegistryClient, LoadReplicationPolicies([]ReplicationPolicy{
+		{Namespace: namespace, Name: repoName, Remotes: []ReplicationRemote{{URL: "remote.example.com/" + repoName}}},
+	}))
+
+	desc, err := controller.mirrorTag(context.Background(), r, tag)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if desc.Digest != mirroredDigest {
+		t.Fatalf("unexpected mirrored digest: %v", desc.Digest)
+	}
+
+	var created *imageapi.ImageStreamMapping
+	for _, action := range client.Actions() {
+		createAction, ok := action.(testclient.CreateAction)
+		if !ok || action.GetResource() != "imagestreammappings" {
+			continue
+		}
+		created, _ = createAction.GetObject().(*imageapi.ImageStreamMapping)
+	}
+
+	if created == nil {
+		t.Fatalf("expected mirrorTag to create an ImageStreamMapping, but none was created")
+	}
+	if created.Tag != tag || created.Image.Name != string(mirroredDigest) {
+		t.Fatalf("unexpected ImageStreamMapping written: %#+v", created)
+	}
+}
+
+// TestMirrorTagRollsBackOnLabelSelectorMismatch verifies that a lazily-mirrored tag whose image
+// doesn't satisfy the policy's LabelSelector is removed again rather than left mirrored in.
+func TestMirrorTagRollsBackOnLabelSelectorMismatch(t *testing.T) {
+	namespace := "user"
+	repoName := "app"
+	tag := "latest"
+	mirroredDigest := digest.Digest("sha256:" + fmt.Sprintf("%064d", 8))
+
+	client := &testclient.Fake{}
+
+	backupRegistryClient := DefaultRegistryClient
+	DefaultRegistryClient = makeFakeRegistryClient(client, fake.NewSimpleClientset())
+	defer func() { DefaultRegistryClient = backupRegistryClient }()
+
+	stream := &imageapi.ImageStream{Status: imageapi.ImageStreamStatus{Tags: map[string]imageapi.TagEventList{}}}
+	client.AddReactor("get", "imagestreams", imagetest.GetFakeImageStreamGetHandler(t, *stream))
+
+	mirroredImage := imageapi.Image{ObjectMeta: kapi.ObjectMeta{Name: string(mirroredDigest), Labels: map[string]string{"release": "canary"}}}
+	client.AddReactor("get", "images", registrytest.GetFakeImageGetHandler(t, mirroredImage))
+
+	backupNewRemoteRepository := newRemoteRepository
+	newRemoteRepository = func(ctx context.Context, remote ReplicationRemote) (distribution.Repository, error) {
+		return &fakeRemoteRepository{desc: distribution.Descriptor{Digest: mirroredDigest}}, nil
+	}
+	defer func() { newRemoteRepository = backupNewRemoteRepository }()
+
+	r := newTestRepositoryForPullthrough(t, context.Background(), nil, namespace, repoName, client, true)
+
+	controller := newReplicationController(DefaultRegistryClient, LoadReplicationPolicies([]ReplicationPolicy{
+		{
+			Namespace: namespace,
+			Name:      repoName,
+			Remotes:   []ReplicationRemote{{URL: "remote.example.com/" + repoName}},
+			Filter:    ReplicationFilter{LabelSelector: "release=stable"},
+		},
+	}))
+
+	if _, err := controller.mirrorTag(context.Background(), r, tag); err == nil {
+		t.Fatalf("expected an error when the mirrored image doesn't match the policy's label selector")
+	}
+
+	var sawDelete bool
+	for _, action := range client.Actions() {
+		if deleteAction, ok := action.(testclient.DeleteAction); ok && action.GetResource() == "imagestreamtags" && deleteAction.GetName() == imageapi.JoinImageStreamTag(repoName, tag) {
+			sawDelete = true
+		}
+	}
+	if !sawDelete {
+		t.Fatalf("expected the mirrored tag to be rolled back via an imagestreamtags delete, actions: %#+v", client.Actions())
+	}
+}
+
+// TestReconcileDiscoversNewRemoteTags verifies that the eager reconcile loop mirrors in tags the
+// remote exposes that aren't present locally yet, and leaves tags that are already mirrored alone
+// rather than re-pulling them every tick.
+func TestReconcileDiscoversNewRemoteTags(t *testing.T) {
+	namespace := "user"
+	repoName := "app"
+
+	client := &testclient.Fake{}
+
+	backupRegistryClient := DefaultRegistryClient
+	DefaultRegistryClient = makeFakeRegistryClient(client, fake.NewSimpleClientset())
+	defer func() { DefaultRegistryClient = backupRegistryClient }()
+
+	stream := &imageapi.ImageStream{
+		ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: repoName},
+		Status: imageapi.ImageStreamStatus{
+			Tags: map[string]imageapi.TagEventList{
+				"existing": {Items: []imageapi.TagEvent{{Image: "sha256:" + fmt.Sprintf("%064d", 1)}}},
+			},
+		},
+	}
+	client.AddReactor("get", "imagestreams", imagetest.GetFakeImageStreamGetHandler(t, *stream))
+
+	remoteDigests := map[string]digest.Digest{
+		"existing": digest.Digest("sha256:" + fmt.Sprintf("%064d", 1)),
+		"new":      digest.Digest("sha256:" + fmt.Sprintf("%064d", 2)),
+	}
+
+	backupNewRemoteRepository := newRemoteRepository
+	newRemoteRepository = func(ctx context.Context, remote ReplicationRemote) (distribution.Repository, error) {
+		return &fakeRemoteRepository{all: []string{"existing", "new"}, byTag: remoteDigests}, nil
+	}
+	defer func() { newRemoteRepository = backupNewRemoteRepository }()
+
+	controller := newReplicationController(DefaultRegistryClient, LoadReplicationPolicies([]ReplicationPolicy{
+		{Namespace: namespace, Name: repoName, Remotes: []ReplicationRemote{{URL: "remote.example.com/" + repoName}}},
+	}))
+
+	if err := controller.reconcile(context.Background(), controller.policies.Policies()[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var created []*imageapi.ImageStreamMapping
+	for _, action := range client.Actions() {
+		createAction, ok := action.(testclient.CreateAction)
+		if !ok || action.GetResource() != "imagestreammappings" {
+			continue
+		}
+		if mapping, ok := createAction.GetObject().(*imageapi.ImageStreamMapping); ok {
+			created = append(created, mapping)
+		}
+	}
+
+	if len(created) != 1 || created[0].Tag != "new" || created[0].Image.Name != string(remoteDigests["new"]) {
+		t.Fatalf("expected reconcile to mirror only the new remote tag, got: %#+v", created)
+	}
+}
+
+type fakeRemoteRepository struct {
+	distribution.Repository
+	desc  distribution.Descriptor
+	all   []string
+	byTag map[string]digest.Digest
+}
+
+func (r *fakeRemoteRepository) Tags(ctx context.Context) distribution.TagService {
+	return &fakeRemoteTagService{desc: r.desc, all: r.all, byTag: r.byTag}
+}
+
+type fakeRemoteTagService struct {
+	distribution.TagService
+	desc  distribution.Descriptor
+	all   []string
+	byTag map[string]digest.Digest
+}
+
+func (t *fakeRemoteTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	if dgst, ok := t.byTag[tag]; ok {
+		return distribution.Descriptor{Digest: dgst}, nil
+	}
+	return t.desc, nil
+}
+
+func (t *fakeRemoteTagService) All(ctx context.Context) ([]string, error) {
+	return t.all, nil
+}
@@ -0,0 +1,101 @@
+package server
+
+import (
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+
+	imageapi "github.com/openshift/origin/pkg/image/api"
+)
+
+// repository wraps a distribution.Repository to resolve tags and manifests against an OpenShift
+// ImageStream instead of (or, when pullthrough is enabled, in addition to) local storage.
+type repository struct {
+	distribution.Repository
+
+	ctx            context.Context
+	registryClient RegistryClient
+
+	namespace string
+	name      string
+
+	// pullthrough indicates whether this repository may serve content for tags that point at
+	// images OpenShift does not manage, by delegating to the embedded Repository/TagService.
+	pullthrough bool
+
+	// replicator and signaturePolicy are threaded through to every tagService this repository
+	// hands out via Tags; either may be left nil to disable the corresponding feature. They are
+	// ordinary fields (rather than constructor parameters) because they're configured once for
+	// the whole registry and then shared across every repository it resolves, the same way
+	// DefaultRegistryClient is shared rather than passed down from the call site.
+	replicator      *replicationController
+	signaturePolicy TagSignaturePolicy
+}
+
+// newRepositoryForPullthrough builds a repository bound to the ImageStream identified by
+// namespace/name. base supplies the underlying distribution.Repository (its Named(), Manifests(),
+// and Blobs() are used as-is); this function only adds OpenShift-aware tag resolution on top.
+func newRepositoryForPullthrough(ctx context.Context, registryClient RegistryClient, base distribution.Repository, namespace, name string, pullthrough bool) (*repository, error) {
+	return &repository{
+		Repository:     base,
+		ctx:            ctx,
+		registryClient: registryClient,
+		namespace:      namespace,
+		name:           name,
+		pullthrough:    pullthrough,
+	}, nil
+}
+
+// Tags returns the OpenShift-aware distribution.TagService that every caller resolving a tag or
+// repository@sha256:... digest reference against this repository goes through - in particular,
+// docker/distribution's own manifest and tag handlers reach tagService.Get/Tag/Untag exactly this
+// way, not just tests constructing a tagService directly.
+func (r *repository) Tags(ctx context.Context) distribution.TagService {
+	return &tagService{
+		TagService:      r.Repository.Tags(ctx),
+		repo:            r,
+		replicator:      r.replicator,
+		signaturePolicy: r.signaturePolicy,
+	}
+}
+
+// getImageStream retrieves the ImageStream backing this repository, translating a not-found
+// response from the API into distribution.ErrRepositoryUnknown so that callers can treat a
+// missing stream the same as an unknown repository.
+func (r *repository) getImageStream() (*imageapi.ImageStream, error) {
+	osClient, _, err := r.registryClient.Client()
+	if err != nil {
+		return nil, err
+	}
+
+	stream, err := osClient.ImageStreams(r.namespace).Get(r.name)
+	if err != nil {
+		return nil, distribution.ErrRepositoryUnknown{Name: r.namespace + "/" + r.name}
+	}
+	return stream, nil
+}
+
+// isManaged reports whether the Image named imageName was pushed directly to this registry
+// (as opposed to being mirrored in purely for pullthrough), based on its ManagedByOpenShift
+// annotation.
+func (r *repository) isManaged(imageName string) bool {
+	osClient, _, err := r.registryClient.Client()
+	if err != nil {
+		return false
+	}
+
+	image, err := osClient.Images().Get(imageName)
+	if err != nil {
+		return false
+	}
+
+	return image.Annotations[imageapi.ManagedByOpenShiftAnnotation] == "true"
+}
+
+// tagEventForTag returns the most recent TagEvent recorded for tag, if any.
+func tagEventForTag(stream *imageapi.ImageStream, tag string) (imageapi.TagEvent, bool) {
+	history, ok := stream.Status.Tags[tag]
+	if !ok || len(history.Items) == 0 {
+		return imageapi.TagEvent{}, false
+	}
+	return history.Items[0], true
+}
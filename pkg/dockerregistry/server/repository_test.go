@@ -0,0 +1,66 @@
+package server
+
+import (
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/reference"
+
+	"github.com/openshift/origin/pkg/client/testclient"
+)
+
+// stubBaseRepository is a minimal distribution.Repository that returns a fixed TagService from
+// Tags, standing in for whatever pullthrough/storage implementation base normally wraps.
+type stubBaseRepository struct {
+	distribution.Repository
+
+	name reference.Named
+	tags distribution.TagService
+}
+
+func (r *stubBaseRepository) Named() reference.Named                           { return r.name }
+func (r *stubBaseRepository) Tags(ctx context.Context) distribution.TagService { return r.tags }
+
+// TestRepositoryTagsReturnsWiredTagService is a regression test for repository.Tags: every real
+// request reaches tagService (digest references, replication, signature policy, pagination) only
+// through this method, so it must actually be reachable rather than tagService being constructed
+// by hand the way every other test in this package does.
+func TestRepositoryTagsReturnsWiredTagService(t *testing.T) {
+	namespace := "user"
+	name := "app"
+	ctx := context.Background()
+
+	named, err := reference.ParseNamed(namespace + "/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := &stubBaseRepository{name: named, tags: newTestTagService(nil)}
+
+	client := &testclient.Fake{}
+	r := newTestRepositoryForPullthrough(t, ctx, base, namespace, name, client, true)
+
+	replicator := newReplicationController(DefaultRegistryClient, LoadReplicationPolicies(nil))
+	policy := &fakeTagSignaturePolicy{allowed: map[digest.Digest]bool{}}
+	r.replicator = replicator
+	r.signaturePolicy = policy
+
+	ts, ok := r.Tags(ctx).(*tagService)
+	if !ok {
+		t.Fatalf("expected repository.Tags to return a *tagService, got %T", r.Tags(ctx))
+	}
+
+	if ts.TagService != base.tags {
+		t.Fatalf("expected the wrapped tagService to delegate to the base Repository's TagService")
+	}
+	if ts.repo != r {
+		t.Fatalf("expected the wrapped tagService to point back at this repository")
+	}
+	if ts.replicator != replicator {
+		t.Fatalf("expected repository.Tags to wire through its replicator")
+	}
+	if ts.signaturePolicy != policy {
+		t.Fatalf("expected repository.Tags to wire through its signaturePolicy")
+	}
+}
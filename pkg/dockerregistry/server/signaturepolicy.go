@@ -0,0 +1,72 @@
+package server
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+)
+
+// ErrTagForbidden is returned when a tag write is rejected because the target descriptor does
+// not carry a valid signature from a signer trusted for that repository.
+type ErrTagForbidden struct {
+	Tag    string
+	Reason string
+}
+
+func (e ErrTagForbidden) Error() string {
+	return fmt.Sprintf("tag %q forbidden: %s", e.Tag, e.Reason)
+}
+
+// TagSignaturePolicy gates tag writes on the presence of a valid signature from a trusted
+// signer, giving admins a "tag == promotion" control similar to Docker Content Trust.
+type TagSignaturePolicy interface {
+	// Verify returns nil if desc may be written as tag in repo, or an error (ordinarily an
+	// ErrTagForbidden) explaining why the write is rejected.
+	Verify(ctx context.Context, repo *repository, tag string, desc distribution.Descriptor) error
+}
+
+// SignerRule maps repository names matching Glob (e.g. "myproject/*") to the signers trusted to
+// promote images into them.
+type SignerRule struct {
+	Glob    string
+	Signers []string
+}
+
+// SignatureVerifier checks desc against a signer's published signatures (a Notary/TUF root of
+// trust, or OpenShift ImageSignature objects), returning an error if none of trustedSigners has
+// signed it.
+type SignatureVerifier func(ctx context.Context, desc distribution.Descriptor, trustedSigners []string) error
+
+// globSignaturePolicy is a TagSignaturePolicy backed by a static list of repo-name glob rules.
+// The first rule whose Glob matches the repository governs it; repositories matched by no rule
+// are left unrestricted.
+type globSignaturePolicy struct {
+	rules  []SignerRule
+	verify SignatureVerifier
+}
+
+// LoadTagSignaturePolicy builds a TagSignaturePolicy from repo-name glob rules, checking
+// descriptors against each rule's signers with verify.
+func LoadTagSignaturePolicy(rules []SignerRule, verify SignatureVerifier) TagSignaturePolicy {
+	return &globSignaturePolicy{rules: rules, verify: verify}
+}
+
+func (p *globSignaturePolicy) Verify(ctx context.Context, repo *repository, tag string, desc distribution.Descriptor) error {
+	repoName := repo.namespace + "/" + repo.name
+
+	for _, rule := range p.rules {
+		ok, err := path.Match(rule.Glob, repoName)
+		if err != nil || !ok {
+			continue
+		}
+
+		if err := p.verify(ctx, desc, rule.Signers); err != nil {
+			return ErrTagForbidden{Tag: tag, Reason: err.Error()}
+		}
+		return nil
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/context"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/reference"
+
+	kapi "k8s.io/kubernetes/pkg/api"
+
+	imageapi "github.com/openshift/origin/pkg/image/api"
+)
+
+// tagService wraps a distribution.TagService to resolve tags against an OpenShift ImageStream,
+// falling back to the embedded TagService (ordinarily a pullthrough-aware implementation) for
+// tags that point at images OpenShift does not manage.
+type tagService struct {
+	distribution.TagService
+
+	repo *repository
+
+	// replicator, when non-nil, is consulted on a local tag miss so that tags covered by a
+	// cross-registry replication policy can be mirrored in before giving up.
+	replicator *replicationController
+
+	// signaturePolicy, when non-nil, is consulted on every Tag write and can reject it by
+	// returning an error (ordinarily an ErrTagForbidden).
+	signaturePolicy TagSignaturePolicy
+}
+
+var _ distribution.TagService = &tagService{}
+
+// accessible reports whether imageName may be served to the client: either it was pushed
+// directly to this registry, or the repository allows pullthrough for images it doesn't manage.
+func (t *tagService) accessible(imageName string) bool {
+	return t.repo.isManaged(imageName) || t.repo.pullthrough
+}
+
+// tagReference classifies a tagService reference: either a plain tag name (Tag is set and Digest
+// is empty), or a repository@sha256:... reference (Tag is empty and Digest is set).
+type tagReference struct {
+	Tag    string
+	Digest digest.Digest
+}
+
+func (r tagReference) isDigest() bool { return r.Digest != "" }
+
+// parseTagReference classifies input as a Tagged or Digested reference the same way Docker does
+// for `docker pull repo@sha256:...` vs `docker pull repo:tag`, using reference.ParseNamed against
+// the fully qualified form.
+func parseTagReference(repoName, input string) (tagReference, error) {
+	if !strings.Contains(input, "@") {
+		return tagReference{Tag: input}, nil
+	}
+
+	named, err := reference.ParseNamed(repoName + "@" + strings.SplitN(input, "@", 2)[1])
+	if err != nil {
+		return tagReference{}, fmt.Errorf("invalid digest reference %q: %v", input, err)
+	}
+
+	canonical, ok := named.(reference.Canonical)
+	if !ok {
+		return tagReference{}, fmt.Errorf("%q is not a valid digest reference", input)
+	}
+
+	return tagReference{Digest: canonical.Digest()}, nil
+}
+
+// descriptorForDigest scans stream for a tag currently pointing at dgst, returning its Descriptor
+// and tag name; it is the shared lookup behind the digest paths of both Get and Untag.
+func descriptorForDigest(stream *imageapi.ImageStream, dgst digest.Digest) (distribution.Descriptor, string, bool) {
+	for tag, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		if digest.Digest(history.Items[0].Image) == dgst {
+			return distribution.Descriptor{Digest: dgst}, tag, true
+		}
+	}
+	return distribution.Descriptor{}, "", false
+}
+
+// acceptMirroredTag applies the same gates to a just-mirrored descriptor that a normal Tag write
+// would: it must be accessible, and it must satisfy any configured signaturePolicy, before Get
+// may hand it back to the client.
+func (t *tagService) acceptMirroredTag(ctx context.Context, tag string, desc distribution.Descriptor) (distribution.Descriptor, error) {
+	if !t.accessible(string(desc.Digest)) {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+
+	if t.signaturePolicy != nil {
+		if err := t.signaturePolicy.Verify(ctx, t.repo, tag, desc); err != nil {
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	return desc, nil
+}
+
+// Get returns the descriptor for tag, which may be a plain tag name or a repository@sha256:...
+// digest reference. For a digest reference it resolves directly against status.tags by digest
+// rather than by tag name. Plain tag names that aren't present locally are mirrored in from a
+// configured remote registry first, if a replication policy covers them.
+func (t *tagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	ref, err := parseTagReference(t.repo.namespace+"/"+t.repo.name, tag)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	stream, err := t.repo.getImageStream()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	if ref.isDigest() {
+		desc, _, ok := descriptorForDigest(stream, ref.Digest)
+		if !ok || !t.accessible(string(ref.Digest)) {
+			return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+		}
+		return desc, nil
+	}
+
+	tagEvent, ok := tagEventForTag(stream, ref.Tag)
+	if !ok {
+		if t.replicator != nil {
+			if mirrored, mirrorErr := t.replicator.mirrorTag(ctx, t.repo, ref.Tag); mirrorErr == nil {
+				return t.acceptMirroredTag(ctx, ref.Tag, mirrored)
+			}
+		}
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+
+	if !t.accessible(tagEvent.Image) {
+		return distribution.Descriptor{}, distribution.ErrTagUnknown{Tag: tag}
+	}
+
+	return distribution.Descriptor{Digest: digest.Digest(tagEvent.Image)}, nil
+}
+
+// Tag records tag as pointing at desc by creating an ImageStreamMapping. Images that OpenShift
+// does not manage can only be tagged when the repository allows pullthrough, and if a
+// signaturePolicy is configured the write is rejected unless desc carries a signature it trusts.
+// If tag is a repository@sha256:... digest reference, the descriptor's digest must match it
+// exactly; since a digest reference is immutable there is nothing further to write once the
+// digests agree.
+func (t *tagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	ref, err := parseTagReference(t.repo.namespace+"/"+t.repo.name, tag)
+	if err != nil {
+		return err
+	}
+
+	if _, err := t.repo.getImageStream(); err != nil {
+		return err
+	}
+
+	if !t.accessible(string(desc.Digest)) {
+		return distribution.ErrTagUnknown{Tag: tag}
+	}
+
+	if t.signaturePolicy != nil {
+		if err := t.signaturePolicy.Verify(ctx, t.repo, tag, desc); err != nil {
+			return err
+		}
+	}
+
+	if ref.isDigest() {
+		if ref.Digest != desc.Digest {
+			return fmt.Errorf("digest reference %s does not match descriptor digest %s", ref.Digest, desc.Digest)
+		}
+		return nil
+	}
+
+	osClient, _, err := t.repo.registryClient.Client()
+	if err != nil {
+		return err
+	}
+
+	_, err = osClient.ImageStreamMappings(t.repo.namespace).Create(&imageapi.ImageStreamMapping{
+		ObjectMeta: kapi.ObjectMeta{Namespace: t.repo.namespace, Name: t.repo.name},
+		Tag:        ref.Tag,
+		Image: imageapi.Image{
+			ObjectMeta: kapi.ObjectMeta{Name: string(desc.Digest)},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	tagIndexCacheSingleton.invalidate(t.repo.indexKey())
+	return nil
+}
+
+// Untag removes tag from the ImageStream backing this repository. tag may be a plain tag name or
+// a repository@sha256:... digest reference, in which case the tag currently pointing at that
+// digest (if any) is removed.
+func (t *tagService) Untag(ctx context.Context, tag string) error {
+	ref, err := parseTagReference(t.repo.namespace+"/"+t.repo.name, tag)
+	if err != nil {
+		return err
+	}
+
+	stream, err := t.repo.getImageStream()
+	if err != nil {
+		return err
+	}
+
+	tagName := ref.Tag
+	var image string
+	if ref.isDigest() {
+		_, matchedTag, ok := descriptorForDigest(stream, ref.Digest)
+		if !ok {
+			return distribution.ErrTagUnknown{Tag: tag}
+		}
+		tagName = matchedTag
+		image = string(ref.Digest)
+	} else {
+		tagEvent, ok := tagEventForTag(stream, tagName)
+		if !ok {
+			return distribution.ErrTagUnknown{Tag: tag}
+		}
+		image = tagEvent.Image
+	}
+
+	if !t.accessible(image) {
+		return distribution.ErrTagUnknown{Tag: tag}
+	}
+
+	osClient, _, err := t.repo.registryClient.Client()
+	if err != nil {
+		return err
+	}
+
+	if err := osClient.ImageStreamTags(t.repo.namespace).Delete(imageapi.JoinImageStreamTag(t.repo.name, tagName), nil); err != nil {
+		return err
+	}
+
+	tagIndexCacheSingleton.invalidate(t.repo.indexKey())
+	return nil
+}
+
+// All returns the names of every tag on the ImageStream that is currently accessible to the
+// client.
+func (t *tagService) All(ctx context.Context) ([]string, error) {
+	stream, err := t.repo.getImageStream()
+	if err != nil {
+		return nil, err
+	}
+
+	tags := []string{}
+	for tag, history := range stream.Status.Tags {
+		if len(history.Items) == 0 {
+			continue
+		}
+		if t.accessible(history.Items[0].Image) {
+			tags = append(tags, tag)
+		}
+	}
+	return tags, nil
+}
+
+// Lookup returns the names of every accessible tag that currently points at desc, served from a
+// digest->tags reverse index rather than a scan of every tag on the stream.
+func (t *tagService) Lookup(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
+	stream, err := t.repo.getImageStream()
+	if err != nil {
+		return nil, err
+	}
+
+	if !t.accessible(string(desc.Digest)) {
+		return []string{}, nil
+	}
+
+	tags := []string{}
+	for _, tag := range t.reverseIndex(stream).tags[desc.Digest] {
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
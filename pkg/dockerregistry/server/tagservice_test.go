@@ -114,6 +114,21 @@ func TestTagGet(t *testing.T) {
 			expectedError:         true,
 			expectedNotFoundError: true,
 		},
+		{
+			title:        "get valid tag by name@digest reference",
+			tagName:      fmt.Sprintf("%s/%s@%s", namespace, repo, testImage.Name),
+			tagValue:     distribution.Descriptor{Digest: digest.Digest(testImage.Name)},
+			pullthrough:  true,
+			imageManaged: true,
+		},
+		{
+			title:                 "get name@digest reference for an unknown digest",
+			tagName:               fmt.Sprintf("%s/%s@%s", namespace, repo, etcdDigest),
+			pullthrough:           true,
+			imageManaged:          true,
+			expectedError:         true,
+			expectedNotFoundError: true,
+		},
 	}
 
 	for _, tc := range testcases {
@@ -329,6 +344,156 @@ func TestTagCreationWithoutImageStream(t *testing.T) {
 	}
 }
 
+func TestTagByDigestReference(t *testing.T) {
+	namespace := "user"
+	repo := "app"
+	tag := "latest"
+	client := &testclient.Fake{}
+
+	// TODO: get rid of those nasty global vars
+	backupRegistryClient := DefaultRegistryClient
+	DefaultRegistryClient = makeFakeRegistryClient(client, fake.NewSimpleClientset())
+	defer func() {
+		// set it back once this test finishes to make other unit tests working again
+		DefaultRegistryClient = backupRegistryClient
+	}()
+
+	ctx := context.Background()
+	serverURL, _ := url.Parse("docker.io/centos")
+
+	testImage := createTestImageReactor(t, client, serverURL, namespace, repo)
+	createTestImageStreamReactor(t, client, testImage, namespace, repo, tag)
+	testImage.Annotations[imageapi.ManagedByOpenShiftAnnotation] = "true"
+
+	localTagService := newTestTagService(nil)
+	r := newTestRepositoryForPullthrough(t, ctx, nil, namespace, repo, client, true)
+	ts := &tagService{
+		TagService: localTagService,
+		repo:       r,
+	}
+
+	digestRef := fmt.Sprintf("%s/%s@%s", namespace, repo, testImage.Name)
+
+	if err := ts.Tag(ctx, digestRef, distribution.Descriptor{Digest: digest.Digest(testImage.Name)}); err != nil {
+		t.Fatalf("unexpected error tagging a matching digest reference: %#+v", err)
+	}
+
+	err := ts.Tag(ctx, digestRef, distribution.Descriptor{Digest: digest.Digest(etcdDigest)})
+	if err == nil {
+		t.Fatalf("error expected when the descriptor doesn't match the digest reference")
+	}
+}
+
+// TestTagGetThenLookupByDigestReference exercises a digest reference end-to-end across Get and
+// Lookup: resolving "namespace/repo@sha256:..." through Get must yield the same descriptor that
+// Lookup, given only the digest, reports the underlying tag name for.
+func TestTagGetThenLookupByDigestReference(t *testing.T) {
+	namespace := "user"
+	repo := "app"
+	tag := "latest"
+	client := &testclient.Fake{}
+
+	// TODO: get rid of those nasty global vars
+	backupRegistryClient := DefaultRegistryClient
+	DefaultRegistryClient = makeFakeRegistryClient(client, fake.NewSimpleClientset())
+	defer func() {
+		// set it back once this test finishes to make other unit tests working again
+		DefaultRegistryClient = backupRegistryClient
+	}()
+
+	ctx := context.Background()
+	serverURL, _ := url.Parse("docker.io/centos")
+
+	testImage := createTestImageReactor(t, client, serverURL, namespace, repo)
+	createTestImageStreamReactor(t, client, testImage, namespace, repo, tag)
+	testImage.Annotations[imageapi.ManagedByOpenShiftAnnotation] = "true"
+
+	localTagService := newTestTagService(nil)
+	r := newTestRepositoryForPullthrough(t, ctx, nil, namespace, repo, client, true)
+	ts := &tagService{
+		TagService: localTagService,
+		repo:       r,
+	}
+
+	digestRef := fmt.Sprintf("%s/%s@%s", namespace, repo, testImage.Name)
+
+	desc, err := ts.Get(ctx, digestRef)
+	if err != nil {
+		t.Fatalf("unexpected error resolving digest reference: %#+v", err)
+	}
+	if desc.Digest != digest.Digest(testImage.Name) {
+		t.Fatalf("unexpected descriptor: %#+v", desc)
+	}
+
+	result, err := ts.Lookup(ctx, desc)
+	if err != nil {
+		t.Fatalf("unexpected error: %#+v", err)
+	}
+	if !reflect.DeepEqual(result, []string{tag}) {
+		t.Fatalf("unexpected lookup result: %#+v", result)
+	}
+}
+
+// fakeTagSignaturePolicy records every repo/tag/descriptor it is asked to verify and rejects
+// writes whose descriptor digest is not in allowed.
+type fakeTagSignaturePolicy struct {
+	allowed map[digest.Digest]bool
+	calls   []string
+}
+
+func (p *fakeTagSignaturePolicy) Verify(ctx context.Context, repo *repository, tag string, desc distribution.Descriptor) error {
+	p.calls = append(p.calls, fmt.Sprintf("%s/%s:%s", repo.namespace, repo.name, tag))
+	if !p.allowed[desc.Digest] {
+		return ErrTagForbidden{Tag: tag, Reason: "no trusted signature found"}
+	}
+	return nil
+}
+
+func TestTagSignaturePolicy(t *testing.T) {
+	namespace := "user"
+	repo := "app"
+	tag := "latest"
+	client := &testclient.Fake{}
+
+	// TODO: get rid of those nasty global vars
+	backupRegistryClient := DefaultRegistryClient
+	DefaultRegistryClient = makeFakeRegistryClient(client, fake.NewSimpleClientset())
+	defer func() {
+		// set it back once this test finishes to make other unit tests working again
+		DefaultRegistryClient = backupRegistryClient
+	}()
+
+	ctx := context.Background()
+	serverURL, _ := url.Parse("docker.io/centos")
+
+	testImage := createTestImageReactor(t, client, serverURL, namespace, repo)
+	createTestImageStreamReactor(t, client, testImage, namespace, repo, tag)
+	testImage.Annotations[imageapi.ManagedByOpenShiftAnnotation] = "true"
+
+	policy := &fakeTagSignaturePolicy{allowed: map[digest.Digest]bool{digest.Digest(testImage.Name): true}}
+
+	localTagService := newTestTagService(nil)
+	r := newTestRepositoryForPullthrough(t, ctx, nil, namespace, repo, client, true)
+	ts := &tagService{
+		TagService:      localTagService,
+		repo:            r,
+		signaturePolicy: policy,
+	}
+
+	if err := ts.Tag(ctx, tag+"-signed", distribution.Descriptor{Digest: digest.Digest(testImage.Name)}); err != nil {
+		t.Fatalf("unexpected error tagging a signed descriptor: %#+v", err)
+	}
+
+	err := ts.Tag(ctx, tag+"-unsigned", distribution.Descriptor{Digest: digest.Digest(etcdDigest)})
+	if _, ok := err.(ErrTagForbidden); !ok {
+		t.Fatalf("expected ErrTagForbidden, got: %#+v", err)
+	}
+
+	if len(policy.calls) != 2 {
+		t.Fatalf("expected the policy to be consulted for both tag writes, got: %v", policy.calls)
+	}
+}
+
 func TestTagDeletion(t *testing.T) {
 	namespace := "user"
 	repo := "app"
@@ -384,6 +549,19 @@ func TestTagDeletion(t *testing.T) {
 			tagName:               tag + "-not-found",
 			expectedNotFoundError: true,
 		},
+		{
+			title:        "delete by name@digest reference",
+			tagName:      fmt.Sprintf("%s/%s@%s", namespace, repo, testImage.Name),
+			pullthrough:  true,
+			imageManaged: true,
+		},
+		{
+			title:                 "delete by name@digest reference for an unknown digest",
+			tagName:               fmt.Sprintf("%s/%s@%s", namespace, repo, etcdDigest),
+			pullthrough:           true,
+			imageManaged:          true,
+			expectedNotFoundError: true,
+		},
 	}
 
 	for _, tc := range testcases {